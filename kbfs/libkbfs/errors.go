@@ -0,0 +1,24 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "fmt"
+
+// TlfNameNotCanonical is returned by ParseTlfHandle when Name isn't
+// canonical -- e.g. its writers/readers aren't sorted, a writer also
+// appears as a reader, or an unresolved assertion has since resolved
+// -- along with NameToTry, the canonical form to retry with.  See
+// ParseTlfHandleUntilCanonical, which follows this redirect
+// automatically.
+type TlfNameNotCanonical struct {
+	Name      string
+	NameToTry CanonicalTlfName
+}
+
+// Error implements the error interface for TlfNameNotCanonical.
+func (e TlfNameNotCanonical) Error() string {
+	return fmt.Sprintf(
+		"TLF name %s is not canonical (canonical: %s)", e.Name, e.NameToTry)
+}
@@ -0,0 +1,190 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/keybase/client/go/protocol"
+	"golang.org/x/net/context"
+)
+
+// KeyServerEtcdConfig describes how to connect to the etcd v3
+// cluster backing a KeyServerEtcd.
+type KeyServerEtcdConfig struct {
+	// Endpoints is the list of etcd cluster member addresses.
+	Endpoints []string
+	// TLS, if non-nil, is used to secure the connection to the
+	// cluster with a client certificate.
+	TLS *tls.Config
+	// DialTimeout bounds how long to wait when establishing the
+	// initial connection to the cluster.
+	DialTimeout time.Duration
+	// OpTimeout bounds how long any single Get/Put/Delete may take.
+	OpTimeout time.Duration
+	// HalfTTL, if positive, causes server halves to be stored under a
+	// lease with this TTL so ephemeral halves expire on their own,
+	// HalfTTL after they're written. A fresh lease is granted for
+	// each PutTLFCryptKeyServerHalves call (not once at startup), so
+	// the TTL is scoped to each write rather than to the life of the
+	// KeyServerEtcd.
+	HalfTTL time.Duration
+}
+
+const keyServerEtcdPrefix = "/kbfs/keyserver/"
+
+// keyServerEtcdHalfKey is the etcd key under which a server half is
+// stored. It's keyed solely by the half's own (content-addressed) ID,
+// not by uid/kid, since GetTLFCryptKeyServerHalf only has the ID to
+// look it up by.
+func keyServerEtcdHalfKey(serverHalfID TLFCryptKeyServerHalfID) string {
+	return keyServerEtcdPrefix + serverHalfID.String()
+}
+
+// KeyServerEtcd implements the KeyServer interface using an etcd v3
+// cluster as the backing store, so that server-half state can be
+// replicated across a highly-available consensus group instead of
+// living in memory on a single node.
+type KeyServerEtcd struct {
+	config KeyServerEtcdConfig
+	client *clientv3.Client
+}
+
+var _ KeyServer = (*KeyServerEtcd)(nil)
+
+// NewKeyServerEtcd creates a new KeyServerEtcd connected to the
+// cluster described by config. Callers that want latency/error
+// metrics should wrap the result with NewKeyServerMeasured, just as
+// with any other KeyServer implementation.
+func NewKeyServerEtcd(config KeyServerEtcdConfig) (*KeyServerEtcd, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: config.DialTimeout,
+		TLS:         config.TLS,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyServerEtcd{
+		config: config,
+		client: client,
+	}, nil
+}
+
+func (ks *KeyServerEtcd) opContext(ctx context.Context) (
+	context.Context, context.CancelFunc) {
+	if ks.config.OpTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, ks.config.OpTimeout)
+}
+
+// grantLease grants a fresh lease scoped to ks.config.HalfTTL, so a
+// half written under it expires HalfTTL after being written rather
+// than HalfTTL after ks was constructed. It returns a zero LeaseID
+// (meaning: don't attach a lease) if HalfTTL isn't configured.
+func (ks *KeyServerEtcd) grantLease(ctx context.Context) (
+	clientv3.LeaseID, error) {
+	if ks.config.HalfTTL <= 0 {
+		return 0, nil
+	}
+	lease, err := ks.client.Grant(ctx, int64(ks.config.HalfTTL.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return lease.ID, nil
+}
+
+// GetTLFCryptKeyServerHalf implements the KeyServer interface for
+// KeyServerEtcd.
+func (ks *KeyServerEtcd) GetTLFCryptKeyServerHalf(ctx context.Context,
+	serverHalfID TLFCryptKeyServerHalfID) (
+	serverHalf TLFCryptKeyServerHalf, err error) {
+	opCtx, cancel := ks.opContext(ctx)
+	defer cancel()
+
+	resp, err := ks.client.Get(opCtx, keyServerEtcdHalfKey(serverHalfID))
+	if err != nil {
+		return TLFCryptKeyServerHalf{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return TLFCryptKeyServerHalf{}, KeyHalfNotFoundError{serverHalfID}
+	}
+
+	err = serverHalf.UnmarshalBinary(resp.Kvs[0].Value)
+	if err != nil {
+		return TLFCryptKeyServerHalf{}, err
+	}
+	return serverHalf, nil
+}
+
+// PutTLFCryptKeyServerHalves implements the KeyServer interface for
+// KeyServerEtcd. All of the halves are written atomically via a
+// single etcd transaction.
+func (ks *KeyServerEtcd) PutTLFCryptKeyServerHalves(ctx context.Context,
+	serverKeyHalves map[keybase1.UID]map[keybase1.KID]TLFCryptKeyServerHalf) error {
+	opCtx, cancel := ks.opContext(ctx)
+	defer cancel()
+
+	leaseID, err := ks.grantLease(opCtx)
+	if err != nil {
+		return err
+	}
+
+	var ops []clientv3.Op
+	for _, kidMap := range serverKeyHalves {
+		for _, serverHalf := range kidMap {
+			value, err := serverHalf.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			key := keyServerEtcdHalfKey(serverHalf.ID())
+			opts := []clientv3.OpOption{}
+			if leaseID != 0 {
+				opts = append(opts, clientv3.WithLease(leaseID))
+			}
+			ops = append(ops, clientv3.OpPut(key, string(value), opts...))
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	_, err = ks.client.Txn(opCtx).Then(ops...).Commit()
+	return err
+}
+
+// DeleteTLFCryptKeyServerHalf implements the KeyServer interface for
+// KeyServerEtcd.
+func (ks *KeyServerEtcd) DeleteTLFCryptKeyServerHalf(ctx context.Context,
+	uid keybase1.UID, kid keybase1.KID,
+	serverHalfID TLFCryptKeyServerHalfID) error {
+	opCtx, cancel := ks.opContext(ctx)
+	defer cancel()
+
+	_, err := ks.client.Delete(opCtx, keyServerEtcdHalfKey(serverHalfID))
+	return err
+}
+
+// Shutdown implements the KeyServer interface for KeyServerEtcd.
+func (ks *KeyServerEtcd) Shutdown() {
+	ks.client.Close()
+}
+
+// KeyHalfNotFoundError is returned when a TLF crypt key server half
+// cannot be found under its expected etcd key.
+type KeyHalfNotFoundError struct {
+	ID TLFCryptKeyServerHalfID
+}
+
+// Error implements the error interface for KeyHalfNotFoundError.
+func (e KeyHalfNotFoundError) Error() string {
+	return fmt.Sprintf("could not find key half for ID %s", e.ID)
+}
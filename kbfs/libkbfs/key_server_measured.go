@@ -13,13 +13,19 @@ type KeyServerMeasured struct {
 	getTimer    metrics.Timer
 	putTimer    metrics.Timer
 	deleteTimer metrics.Timer
+	exporter    *PrometheusExporter
 }
 
 var _ KeyServer = KeyServerMeasured{}
 
 // NewKeyServerMeasured creates and returns a new KeyServerMeasured
-// instance with the given delegate and registry.
-func NewKeyServerMeasured(delegate KeyServer, r metrics.Registry) KeyServerMeasured {
+// instance with the given delegate and registry. pe may be nil.
+// Either way, timings are recorded in r, and they reach Prometheus
+// through any PrometheusExporter built over r, whose Collect walks r
+// at scrape time.
+func NewKeyServerMeasured(
+	delegate KeyServer, r metrics.Registry,
+	pe *PrometheusExporter) KeyServerMeasured {
 	getTimer := metrics.GetOrRegisterTimer("KeyServer.GetTLFCryptKeyServerHalf", r)
 	putTimer := metrics.GetOrRegisterTimer("KeyServer.PutTLFCryptKeyServerHalves", r)
 	deleteTimer := metrics.GetOrRegisterTimer("KeyServer.DeleteTLFCryptKeyServerHalf", r)
@@ -28,17 +34,40 @@ func NewKeyServerMeasured(delegate KeyServer, r metrics.Registry) KeyServerMeasu
 		getTimer:    getTimer,
 		putTimer:    putTimer,
 		deleteTimer: deleteTimer,
+		exporter:    pe,
 	}
 }
 
+const (
+	keyServerOpGet    = "KeyServer.GetTLFCryptKeyServerHalf"
+	keyServerOpPut    = "KeyServer.PutTLFCryptKeyServerHalves"
+	keyServerOpDelete = "KeyServer.DeleteTLFCryptKeyServerHalf"
+)
+
+// timeOp runs f, timing it against timer (via b.exporter.TimeOp if an
+// exporter was configured, which also just calls timer.Time). The
+// Prometheus summary for timer is built from it at scrape time. There's
+// no TLF ID to label these with: KeyServer's methods are keyed by
+// server-half ID (Get) or by uid/kid (Put, Delete), not by TLF ID, so
+// opsTotal carries only op/result labels, and the per-op summaries
+// none.
+func (b KeyServerMeasured) timeOp(name string, timer metrics.Timer, f func()) {
+	if b.exporter != nil {
+		b.exporter.TimeOp(name, timer, f)
+		return
+	}
+	timer.Time(f)
+}
+
 // GetTLFCryptKeyServerHalf implements the KeyServer interface for
 // KeyServerMeasured.
 func (b KeyServerMeasured) GetTLFCryptKeyServerHalf(ctx context.Context,
 	serverHalfID TLFCryptKeyServerHalfID) (
 	serverHalf TLFCryptKeyServerHalf, err error) {
-	b.getTimer.Time(func() {
+	b.timeOp(keyServerOpGet, b.getTimer, func() {
 		serverHalf, err = b.delegate.GetTLFCryptKeyServerHalf(ctx, serverHalfID)
 	})
+	recordOpResult(keyServerOpGet, err)
 	return serverHalf, err
 }
 
@@ -46,9 +75,10 @@ func (b KeyServerMeasured) GetTLFCryptKeyServerHalf(ctx context.Context,
 // KeyServerMeasured.
 func (b KeyServerMeasured) PutTLFCryptKeyServerHalves(ctx context.Context,
 	serverKeyHalves map[keybase1.UID]map[keybase1.KID]TLFCryptKeyServerHalf) (err error) {
-	b.putTimer.Time(func() {
+	b.timeOp(keyServerOpPut, b.putTimer, func() {
 		err = b.delegate.PutTLFCryptKeyServerHalves(ctx, serverKeyHalves)
 	})
+	recordOpResult(keyServerOpPut, err)
 	return err
 }
 
@@ -57,10 +87,11 @@ func (b KeyServerMeasured) PutTLFCryptKeyServerHalves(ctx context.Context,
 func (b KeyServerMeasured) DeleteTLFCryptKeyServerHalf(ctx context.Context,
 	uid keybase1.UID, kid keybase1.KID,
 	serverHalfID TLFCryptKeyServerHalfID) (err error) {
-	b.deleteTimer.Time(func() {
+	b.timeOp(keyServerOpDelete, b.deleteTimer, func() {
 		err = b.delegate.DeleteTLFCryptKeyServerHalf(
 			ctx, uid, kid, serverHalfID)
 	})
+	recordOpResult(keyServerOpDelete, err)
 	return err
 }
 
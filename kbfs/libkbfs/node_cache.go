@@ -7,11 +7,109 @@ package libkbfs
 import (
 	"fmt"
 	"sync"
+	"time"
 )
 
 type nodeCacheEntry struct {
 	core     *nodeCore
 	refCount int
+
+	// selfGen is stamped to the cache's pathGen counter every time
+	// this entry's parent, name, or pointer changes.  A cached path
+	// that recorded an older selfGen for any node along its chain is
+	// stale.
+	selfGen uint64
+
+	// memoLock guards memoPath/memoGens, which PathFromNode
+	// populates lazily.  It's separate from nodeCacheStandard.lock
+	// because PathFromNode only needs a read lock on the cache, but
+	// still wants to memoize the path it just built.
+	memoLock sync.Mutex
+	// memoPath is the last path PathFromNode computed rooted at this
+	// entry, in the same root-to-leaf order as path.path.
+	memoPath []pathNode
+	// memoGens[i] is the selfGen of the entry owning memoPath[i], as
+	// of when memoPath was computed.
+	memoGens []uint64
+}
+
+// NodeCacheEventType describes the kind of change a NodeCacheEvent
+// represents.
+type NodeCacheEventType int
+
+const (
+	// NodeCacheEventCreate is sent when GetOrCreate creates a brand
+	// new entry for a ref.
+	NodeCacheEventCreate NodeCacheEventType = iota
+	// NodeCacheEventUpdatePointer is sent when UpdatePointer changes
+	// the BlockPointer underlying a ref.  Successive events for the
+	// same ref within nodeCacheCoalesceWindow are coalesced into one.
+	NodeCacheEventUpdatePointer
+	// NodeCacheEventMove is sent when Move re-parents a ref.
+	NodeCacheEventMove
+	// NodeCacheEventUnlink is sent when Unlink removes a ref from
+	// its parent.
+	NodeCacheEventUnlink
+	// NodeCacheEventEvict is sent when a ref's refcount drops to
+	// zero and it is evicted from the cache.
+	NodeCacheEventEvict
+	// NodeCacheEventOverflow is sent to a subscriber in place of any
+	// events it couldn't keep up with, so it knows to resync by
+	// other means (e.g. a fresh PathFromNode or Get).
+	NodeCacheEventOverflow
+)
+
+// NodeCacheEvent describes a single change to a nodeCacheStandard
+// entry, for consumers that want inotify/FSEvents-style
+// notifications instead of polling PathFromNode.
+type NodeCacheEvent struct {
+	Type NodeCacheEventType
+	Ref  blockRef
+	// NewRef is only set for NodeCacheEventUpdatePointer, and holds
+	// the ref the entry moved to.
+	NewRef blockRef
+}
+
+// CancelFunc unsubscribes a previously-created watch and releases
+// its buffer.
+type CancelFunc func()
+
+// nodeCacheSubBufferSize bounds how many undelivered events a single
+// subscriber can accumulate before it starts missing events and gets
+// sent a NodeCacheEventOverflow instead.
+const nodeCacheSubBufferSize = 32
+
+// nodeCacheCoalesceWindow is how long nodeCacheStandard waits after
+// the first UpdatePointer event for a given ref before publishing
+// it, so that a burst of pointer updates for the same ref (as
+// happens during a sync) only generates one event.
+const nodeCacheCoalesceWindow = 10 * time.Millisecond
+
+type nodeCacheSub struct {
+	ch       chan NodeCacheEvent
+	ref      blockRef // zero for a WatchAll subscriber
+	isGlobal bool
+	overflow bool
+}
+
+func (s *nodeCacheSub) send(event NodeCacheEvent) {
+	if s.overflow {
+		// The subscriber missed events earlier; once its buffer has
+		// room, deliver the overflow marker before anything newer.
+		select {
+		case s.ch <- NodeCacheEvent{Type: NodeCacheEventOverflow, Ref: s.ref}:
+			s.overflow = false
+		default:
+			return
+		}
+	}
+	select {
+	case s.ch <- event:
+	default:
+		// The subscriber isn't keeping up. Drop this event, and mark
+		// it so that a later send delivers the overflow marker first.
+		s.overflow = true
+	}
 }
 
 // nodeCacheStandard implements the NodeCache interface by tracking
@@ -21,6 +119,26 @@ type nodeCacheStandard struct {
 	folderBranch FolderBranch
 	nodes        map[blockRef]*nodeCacheEntry
 	lock         sync.RWMutex
+
+	// pathGen is bumped under lock every time Move, UpdatePointer, or
+	// Unlink structurally changes an entry, and the new value is
+	// stamped onto that entry's selfGen.  PathFromNode uses it to
+	// tell whether a memoized path is still good without having to
+	// rebuild it.
+	pathGen uint64
+
+	subLock    sync.Mutex
+	nextSubID  uint64
+	subsByRef  map[blockRef]map[uint64]*nodeCacheSub
+	globalSubs map[uint64]*nodeCacheSub
+	coalesced  map[blockRef]*nodeCacheEvent
+}
+
+// nodeCacheEvent tracks a pending, not-yet-published event for a
+// ref, along with the timer that will flush it.
+type nodeCacheEvent struct {
+	event NodeCacheEvent
+	timer *time.Timer
 }
 
 var _ NodeCache = (*nodeCacheStandard)(nil)
@@ -29,32 +147,141 @@ func newNodeCacheStandard(fb FolderBranch) *nodeCacheStandard {
 	return &nodeCacheStandard{
 		folderBranch: fb,
 		nodes:        make(map[blockRef]*nodeCacheEntry),
+		subsByRef:    make(map[blockRef]map[uint64]*nodeCacheSub),
+		globalSubs:   make(map[uint64]*nodeCacheSub),
+		coalesced:    make(map[blockRef]*nodeCacheEvent),
 	}
 }
 
-// lock must be locked for writing by the caller
-func (ncs *nodeCacheStandard) forgetLocked(core *nodeCore) {
+// Watch subscribes to events for a single ref. The returned channel
+// is closed-over internally and must not be closed by the caller;
+// call the returned CancelFunc instead, which also releases the
+// subscriber's buffer.
+func (ncs *nodeCacheStandard) Watch(ref blockRef) (
+	<-chan NodeCacheEvent, CancelFunc) {
+	return ncs.watch(ref, false)
+}
+
+// WatchAll subscribes to events for every ref in the cache.
+func (ncs *nodeCacheStandard) WatchAll() (<-chan NodeCacheEvent, CancelFunc) {
+	return ncs.watch(blockRef{}, true)
+}
+
+func (ncs *nodeCacheStandard) watch(ref blockRef, isGlobal bool) (
+	<-chan NodeCacheEvent, CancelFunc) {
+	sub := &nodeCacheSub{
+		ch:       make(chan NodeCacheEvent, nodeCacheSubBufferSize),
+		ref:      ref,
+		isGlobal: isGlobal,
+	}
+
+	ncs.subLock.Lock()
+	id := ncs.nextSubID
+	ncs.nextSubID++
+	if isGlobal {
+		ncs.globalSubs[id] = sub
+	} else {
+		subs, ok := ncs.subsByRef[ref]
+		if !ok {
+			subs = make(map[uint64]*nodeCacheSub)
+			ncs.subsByRef[ref] = subs
+		}
+		subs[id] = sub
+	}
+	ncs.subLock.Unlock()
+
+	cancel := func() {
+		ncs.subLock.Lock()
+		defer ncs.subLock.Unlock()
+		if isGlobal {
+			delete(ncs.globalSubs, id)
+		} else if subs, ok := ncs.subsByRef[ref]; ok {
+			delete(subs, id)
+			if len(subs) == 0 {
+				delete(ncs.subsByRef, ref)
+			}
+		}
+	}
+	return sub.ch, cancel
+}
+
+// publish delivers event to every subscriber watching ref, plus
+// every WatchAll subscriber.  It must be called without ncs.lock
+// held, so that subscribers reacting to the event (e.g. by calling
+// PathFromNode) can't re-enter nodeCacheStandard while it's locked.
+func (ncs *nodeCacheStandard) publish(event NodeCacheEvent) {
+	ncs.subLock.Lock()
+	defer ncs.subLock.Unlock()
+	for _, sub := range ncs.subsByRef[event.Ref] {
+		sub.send(event)
+	}
+	for _, sub := range ncs.globalSubs {
+		sub.send(event)
+	}
+}
+
+// publishCoalesced schedules event to be published after
+// nodeCacheCoalesceWindow, replacing any pending event already
+// scheduled for the same ref so that a burst of updates for one ref
+// results in a single published event.
+func (ncs *nodeCacheStandard) publishCoalesced(event NodeCacheEvent) {
+	ncs.subLock.Lock()
+	defer ncs.subLock.Unlock()
+
+	if pending, ok := ncs.coalesced[event.Ref]; ok {
+		pending.event = event
+		return
+	}
+
+	pending := &nodeCacheEvent{event: event}
+	pending.timer = time.AfterFunc(nodeCacheCoalesceWindow, func() {
+		ncs.subLock.Lock()
+		p, ok := ncs.coalesced[event.Ref]
+		if ok {
+			delete(ncs.coalesced, event.Ref)
+		}
+		ncs.subLock.Unlock()
+		if ok {
+			ncs.publish(p.event)
+		}
+	})
+	ncs.coalesced[event.Ref] = pending
+}
+
+// lock must be locked for writing by the caller.  The returned
+// blockRef and bool indicate whether an entry was actually evicted,
+// so the caller can publish a NodeCacheEventEvict once it's unlocked.
+func (ncs *nodeCacheStandard) forgetLocked(core *nodeCore) (blockRef, bool) {
 	ref := core.pathNode.ref()
 
 	entry, ok := ncs.nodes[ref]
 	if !ok {
-		return
+		return blockRef{}, false
 	}
 	if entry.core != core {
-		return
+		return blockRef{}, false
 	}
 
 	entry.refCount--
 	if entry.refCount <= 0 {
 		delete(ncs.nodes, ref)
+		return ref, true
 	}
+	return blockRef{}, false
 }
 
 // should be called only by nodeStandardFinalizer().
 func (ncs *nodeCacheStandard) forget(core *nodeCore) {
+	var evictedRef blockRef
+	var evicted bool
+	defer func() {
+		if evicted {
+			ncs.publish(NodeCacheEvent{Type: NodeCacheEventEvict, Ref: evictedRef})
+		}
+	}()
 	ncs.lock.Lock()
 	defer ncs.lock.Unlock()
-	ncs.forgetLocked(core)
+	evictedRef, evicted = ncs.forgetLocked(core)
 }
 
 // lock must be held for writing by the caller
@@ -75,6 +302,12 @@ func (ncs *nodeCacheStandard) newChildForParentLocked(parent Node) (*nodeStandar
 	return nodeStandard, nil
 }
 
+// lock must be held for writing by the caller.
+func (ncs *nodeCacheStandard) bumpGenLocked(entry *nodeCacheEntry) {
+	ncs.pathGen++
+	entry.selfGen = ncs.pathGen
+}
+
 func makeNodeStandardForEntry(entry *nodeCacheEntry) *nodeStandard {
 	entry.refCount++
 	return makeNodeStandard(entry.core)
@@ -93,6 +326,15 @@ func (ncs *nodeCacheStandard) GetOrCreate(
 		return nil, EmptyNameError{ptr.ref()}
 	}
 
+	created := false
+	defer func() {
+		if created {
+			ncs.publish(NodeCacheEvent{
+				Type: NodeCacheEventCreate, Ref: ptr.ref(),
+			})
+		}
+	}()
+
 	ncs.lock.Lock()
 	defer ncs.lock.Unlock()
 	entry, ok := ncs.nodes[ptr.ref()]
@@ -119,9 +361,11 @@ func (ncs *nodeCacheStandard) GetOrCreate(
 	}
 
 	entry = &nodeCacheEntry{
-		core: newNodeCore(ptr, name, parentNS, ncs),
+		core:    newNodeCore(ptr, name, parentNS, ncs),
+		selfGen: ncs.pathGen,
 	}
 	ncs.nodes[ptr.ref()] = entry
+	created = true
 	return makeNodeStandardForEntry(entry), nil
 }
 
@@ -161,6 +405,16 @@ func (ncs *nodeCacheStandard) UpdatePointer(
 		panic(fmt.Sprintf("invalid newPtr %s with oldRef %s", newPtr, oldRef))
 	}
 
+	updated := false
+	defer func() {
+		if updated {
+			ncs.publishCoalesced(NodeCacheEvent{
+				Type: NodeCacheEventUpdatePointer,
+				Ref:  oldRef, NewRef: newPtr.ref(),
+			})
+		}
+	}()
+
 	ncs.lock.Lock()
 	defer ncs.lock.Unlock()
 	entry, ok := ncs.nodes[oldRef]
@@ -176,6 +430,8 @@ func (ncs *nodeCacheStandard) UpdatePointer(
 	entry.core.pathNode.BlockPointer = newPtr
 	delete(ncs.nodes, oldRef)
 	ncs.nodes[newPtr.ref()] = entry
+	ncs.bumpGenLocked(entry)
+	updated = true
 }
 
 // Move implements the NodeCache interface for nodeCacheStandard.
@@ -195,6 +451,13 @@ func (ncs *nodeCacheStandard) Move(
 		return EmptyNameError{ref}
 	}
 
+	moved := false
+	defer func() {
+		if moved {
+			ncs.publish(NodeCacheEvent{Type: NodeCacheEventMove, Ref: ref})
+		}
+	}()
+
 	ncs.lock.Lock()
 	defer ncs.lock.Unlock()
 	entry, ok := ncs.nodes[ref]
@@ -209,6 +472,8 @@ func (ncs *nodeCacheStandard) Move(
 
 	entry.core.parent = newParentNS
 	entry.core.pathNode.Name = newName
+	ncs.bumpGenLocked(entry)
+	moved = true
 	return nil
 }
 
@@ -224,6 +489,13 @@ func (ncs *nodeCacheStandard) Unlink(ref blockRef, oldPath path) {
 		panic(InvalidBlockRefError{ref})
 	}
 
+	unlinked := false
+	defer func() {
+		if unlinked {
+			ncs.publish(NodeCacheEvent{Type: NodeCacheEventUnlink, Ref: ref})
+		}
+	}()
+
 	ncs.lock.Lock()
 	defer ncs.lock.Unlock()
 	entry, ok := ncs.nodes[ref]
@@ -234,9 +506,77 @@ func (ncs *nodeCacheStandard) Unlink(ref blockRef, oldPath path) {
 	entry.core.cachedPath = oldPath
 	entry.core.parent = nil
 	entry.core.pathNode.Name = ""
+	ncs.bumpGenLocked(entry)
+	unlinked = true
 	return
 }
 
+// entryLocked looks up the nodeCacheEntry backing ns, if any.  The
+// caller must hold at least ncs.lock for reading.
+func (ncs *nodeCacheStandard) entryLocked(ns *nodeStandard) *nodeCacheEntry {
+	entry, ok := ncs.nodes[ns.core.pathNode.ref()]
+	if !ok || entry.core != ns.core {
+		return nil
+	}
+	return entry
+}
+
+// memoizedPathLocked returns a copy of entry's memoized path, if one
+// is cached and no ancestor's selfGen has advanced since it was
+// computed.  The caller must hold at least ncs.lock for reading.
+func (ncs *nodeCacheStandard) memoizedPathLocked(entry *nodeCacheEntry) (
+	[]pathNode, bool) {
+	entry.memoLock.Lock()
+	defer entry.memoLock.Unlock()
+
+	if entry.memoPath == nil {
+		return nil, false
+	}
+
+	ns := entry.core.parent
+	// memoGens[len-1] is this entry's own gen; walk the ancestors
+	// backwards from there.
+	for i := len(entry.memoGens) - 2; i >= 0; i-- {
+		if ns == nil {
+			return nil, false
+		}
+		ancestor := ncs.entryLocked(ns)
+		if ancestor == nil || ancestor.selfGen != entry.memoGens[i] {
+			return nil, false
+		}
+		ns = ancestor.core.parent
+	}
+	if entry.selfGen != entry.memoGens[len(entry.memoGens)-1] {
+		return nil, false
+	}
+
+	cp := make([]pathNode, len(entry.memoPath))
+	copy(cp, entry.memoPath)
+	return cp, true
+}
+
+// memoizeLocked stores path (and the selfGen of each of its nodes,
+// leaf-first to match how it was built) against entry.  The caller
+// must hold at least ncs.lock for reading.
+func (ncs *nodeCacheStandard) memoizeLocked(
+	entry *nodeCacheEntry, pathNodes []pathNode, gensLeafFirst []uint64) {
+	if entry == nil || len(gensLeafFirst) != len(pathNodes) {
+		return
+	}
+
+	gens := make([]uint64, len(gensLeafFirst))
+	for i, g := range gensLeafFirst {
+		gens[len(gens)-1-i] = g
+	}
+
+	entry.memoLock.Lock()
+	defer entry.memoLock.Unlock()
+	// Keep our own copy: the caller's slice is handed back to
+	// PathFromNode's caller, who may freely mutate it.
+	entry.memoPath = append([]pathNode(nil), pathNodes...)
+	entry.memoGens = gens
+}
+
 // PathFromNode implements the NodeCache interface for nodeCacheStandard.
 func (ncs *nodeCacheStandard) PathFromNode(node Node) (p path) {
 	ncs.lock.RLock()
@@ -248,6 +588,17 @@ func (ncs *nodeCacheStandard) PathFromNode(node Node) (p path) {
 		return
 	}
 
+	leafEntry := ncs.entryLocked(ns)
+	if leafEntry != nil {
+		if cached, ok := ncs.memoizedPathLocked(leafEntry); ok {
+			p.path = cached
+			p.FolderBranch = ncs.folderBranch
+			return
+		}
+	}
+
+	var gensLeafFirst []uint64
+	memoizable := leafEntry != nil
 	for ns != nil {
 		core := ns.core
 		if core.parent == nil && len(core.cachedPath.path) > 0 {
@@ -256,6 +607,7 @@ func (ncs *nodeCacheStandard) PathFromNode(node Node) (p path) {
 			// it backwards one-by-one to the existing path.  If this
 			// is the first node, we can just optimize by returning
 			// the complete cached path.
+			memoizable = false
 			if len(p.path) == 0 {
 				return core.cachedPath
 			}
@@ -266,6 +618,13 @@ func (ncs *nodeCacheStandard) PathFromNode(node Node) (p path) {
 		}
 
 		p.path = append(p.path, *core.pathNode)
+		if memoizable {
+			if entry := ncs.entryLocked(ns); entry != nil {
+				gensLeafFirst = append(gensLeafFirst, entry.selfGen)
+			} else {
+				memoizable = false
+			}
+		}
 		ns = core.parent
 	}
 
@@ -275,7 +634,10 @@ func (ncs *nodeCacheStandard) PathFromNode(node Node) (p path) {
 		p.path[i], p.path[opp] = p.path[opp], p.path[i]
 	}
 
-	// TODO: would it make any sense to cache the constructed path?
+	if memoizable {
+		ncs.memoizeLocked(leafEntry, p.path, gensLeafFirst)
+	}
+
 	p.FolderBranch = ncs.folderBranch
 	return
 }
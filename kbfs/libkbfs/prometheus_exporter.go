@@ -0,0 +1,176 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// opsTotal counts every *Measured delegate call by op and result, so
+// that dashboards can show error rates alongside the existing
+// latency timers.  It's incremented by recordOpResult, which
+// KeyServerMeasured (and, as they gain Prometheus support, the
+// BlockServer/MDServer measured wrappers) call after each delegate
+// call returns.  It isn't labeled by TLF ID: none of KeyServer's
+// methods are keyed by TLF ID (they take a server-half ID, or a
+// uid/kid pair), so there's nothing to label with at these call
+// sites. Wrappers whose delegate methods do carry a TLF ID can add
+// that label when they wire up recordOpResult/TimeOp.  opsTotal is a
+// single shared collector rather than one per PrometheusExporter;
+// each exporter registers it into its own promReg (see
+// NewPrometheusExporter), which prometheus permits for the same
+// collector instance as long as it's only registered once per
+// registry.
+var opsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kbfs",
+	Name:      "ops_total",
+	Help:      "Count of KBFS backend operations by op and result.",
+}, []string{"op", "result"})
+
+// recordOpResult increments opsTotal{op, result} where result is
+// "ok" or "err" depending on whether err is nil.
+func recordOpResult(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "err"
+	}
+	opsTotal.WithLabelValues(op, result).Inc()
+}
+
+// PrometheusExporter bridges the go-metrics Registry already used by
+// KeyServerMeasured and its sibling BlockServer/MDServer wrappers
+// into Prometheus, so the same timers that back the existing
+// stats.log output can also be scraped over HTTP, and exposes
+// opsTotal for error-rate tracking.  It implements prometheus.Collector
+// itself: Collect walks registry at scrape time and turns every
+// metrics.Timer it finds into a Prometheus summary, so any timer
+// registered there -- including ones added later by BlockServer- or
+// MDServerMeasured, which don't exist in this tree yet -- is exported
+// automatically the moment it's registered into registry, with no
+// further plumbing required here.
+type PrometheusExporter struct {
+	registry metrics.Registry
+	addr     string
+	promReg  *prometheus.Registry
+
+	mu     sync.Mutex
+	server *http.Server
+}
+
+// NewPrometheusExporter creates an exporter that serves Prometheus
+// metrics derived from r at addr (e.g. ":9090") under /metrics.  It
+// uses its own prometheus.Registry rather than the global default
+// registerer, so creating more than one PrometheusExporter (e.g. in
+// tests, or for a second KeyServer instance) can't collide.
+func NewPrometheusExporter(r metrics.Registry, addr string) *PrometheusExporter {
+	pe := &PrometheusExporter{
+		registry: r,
+		addr:     addr,
+		promReg:  prometheus.NewRegistry(),
+	}
+	pe.promReg.MustRegister(opsTotal)
+	pe.promReg.MustRegister(pe)
+	return pe
+}
+
+// Describe implements prometheus.Collector.  It intentionally sends
+// nothing: the set of metrics Collect will emit depends on whatever's
+// registered in pe.registry at scrape time and isn't known statically,
+// so pe is registered as an "unchecked" collector (see the
+// prometheus/client_golang Collector docs).
+func (pe *PrometheusExporter) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector by walking pe.registry and
+// translating each metrics.Timer it finds into a Prometheus summary
+// built from that timer's own snapshot (count, sum, and a handful of
+// percentiles), so every timer already registered for the stats.log
+// output -- present and future -- shows up here with no per-call-site
+// wiring.
+func (pe *PrometheusExporter) Collect(ch chan<- prometheus.Metric) {
+	pe.registry.Each(func(name string, i interface{}) {
+		timer, ok := i.(metrics.Timer)
+		if !ok {
+			return
+		}
+		s := timer.Snapshot()
+		desc := prometheus.NewDesc(
+			"kbfs_ops_"+prometheusName(name)+"_seconds",
+			fmt.Sprintf("Latency of %s calls, in seconds.", name),
+			nil, nil)
+		quantiles := map[float64]float64{
+			0.5:  s.Percentile(0.5) / float64(time.Second),
+			0.9:  s.Percentile(0.9) / float64(time.Second),
+			0.99: s.Percentile(0.99) / float64(time.Second),
+		}
+		metric, err := prometheus.NewConstSummary(
+			desc, uint64(s.Count()), float64(s.Sum())/float64(time.Second),
+			quantiles)
+		if err != nil {
+			// A malformed Desc would be a bug in this function, not
+			// in the data; surfacing it as an invalid metric lets
+			// promhttp report it instead of panicking the scrape.
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			return
+		}
+		ch <- metric
+	})
+}
+
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '-', ' ':
+			return '_'
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+// TimeOp calls f, recording its duration against the named go-metrics
+// timer via timer.Time. The timing reaches Prometheus the next time
+// pe is scraped, via Collect walking pe.registry -- TimeOp doesn't
+// need to push anything itself. It's kept as a thin, discoverable
+// wrapper so *Measured call sites have an obvious thing to call
+// instead of timer.Time directly, e.g.:
+//
+//	pe.TimeOp("KeyServer.GetTLFCryptKeyServerHalf", b.getTimer,
+//	    func() { serverHalf, err = b.delegate.GetTLFCryptKeyServerHalf(ctx, id) })
+func (pe *PrometheusExporter) TimeOp(name string, timer metrics.Timer, f func()) {
+	timer.Time(f)
+}
+
+// Serve starts serving Prometheus metrics on pe.addr under /metrics.
+// It blocks until the server stops, mirroring http.ListenAndServe.
+func (pe *PrometheusExporter) Serve() error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(
+		pe.promReg, promhttp.HandlerOpts{}))
+
+	pe.mu.Lock()
+	pe.server = &http.Server{Addr: pe.addr, Handler: mux}
+	server := pe.server
+	pe.mu.Unlock()
+
+	return server.ListenAndServe()
+}
+
+// Shutdown stops the exporter's HTTP server, if running.
+func (pe *PrometheusExporter) Shutdown() error {
+	pe.mu.Lock()
+	server := pe.server
+	pe.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Close()
+}
@@ -1,7 +1,6 @@
 package libkbfs
 
 import (
-	"bytes"
 	"fmt"
 	"sort"
 	"strings"
@@ -9,22 +8,70 @@ import (
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol"
+	"github.com/keybase/kbfs/tlf"
 	"golang.org/x/net/context"
 )
 
 // TlfHandle uniquely identifies top-level folders by readers and
 // writers.  It is go-routine-safe.
 type TlfHandle struct {
-	Readers     []keybase1.UID `codec:"r,omitempty"`
-	Writers     []keybase1.UID `codec:"w,omitempty"`
-	cachedName  string
-	cachedBytes []byte
-	cacheMutex  sync.Mutex // control access to the "cached" values
+	// Type says whether this is a private, public, or single-team
+	// folder.  For a SingleTeam handle, Writers holds exactly one
+	// entry: the team's ID.
+	Type    tlf.Type                `codec:"t,omitempty"`
+	Readers []keybase1.UserOrTeamID `codec:"r,omitempty"`
+	Writers []keybase1.UserOrTeamID `codec:"w,omitempty"`
+	// UnresolvedWriters and UnresolvedReaders hold social
+	// assertions (e.g. "twitter:alice") that haven't yet resolved to
+	// a Keybase UID, in sorted order.  They let a folder be created
+	// and shared with someone before they've signed up on Keybase;
+	// see ResolveAgain for how they eventually get promoted to
+	// Writers/Readers.  They're not meaningful for SingleTeam
+	// handles.
+	UnresolvedWriters []keybase1.SocialAssertion `codec:"uw,omitempty"`
+	UnresolvedReaders []keybase1.SocialAssertion `codec:"ur,omitempty"`
+	// ConflictInfo is set if this handle refers to a conflict-
+	// resolution copy of another handle.
+	ConflictInfo *tlf.HandleExtension `codec:"ci,omitempty"`
+	// FinalizedInfo is set if this handle refers to the contents of
+	// a folder as they stood before one of its writers reset their
+	// Keybase account.
+	FinalizedInfo *tlf.HandleExtension `codec:"fi,omitempty"`
+	cachedName    string
+	cachedBytes   []byte
+	cacheMutex    sync.Mutex // control access to the "cached" values
 }
 
-// NewTlfHandle constructs a new, blank TlfHandle.
+// SocialAssertionList can be used to lexicographically sort social
+// assertions.
+type SocialAssertionList []keybase1.SocialAssertion
+
+func (sa SocialAssertionList) Len() int {
+	return len(sa)
+}
+
+func (sa SocialAssertionList) Less(i, j int) bool {
+	return sa[i].String() < sa[j].String()
+}
+
+func (sa SocialAssertionList) Swap(i, j int) {
+	sa[i], sa[j] = sa[j], sa[i]
+}
+
+// socialAssertionStrings returns the String() of every assertion in
+// sas, in sorted order.
+func socialAssertionStrings(sas []keybase1.SocialAssertion) []string {
+	strs := make([]string, len(sas))
+	for i, sa := range sas {
+		strs[i] = sa.String()
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+// NewTlfHandle constructs a new, blank, private TlfHandle.
 func NewTlfHandle() *TlfHandle {
-	return &TlfHandle{}
+	return &TlfHandle{Type: tlf.Private}
 }
 
 // TlfHandleDecode decodes b into a TlfHandle.
@@ -39,7 +86,8 @@ func TlfHandleDecode(b []byte, config Config) (*TlfHandle, error) {
 }
 
 func identifyUser(ctx context.Context, kbpki KBPKI, name, reason string,
-	errCh chan<- error, results chan<- UserInfo) {
+	errCh chan<- error, results chan<- UserInfo,
+	unresolved chan<- keybase1.SocialAssertion) {
 	// short-circuit if this is the special public user:
 	if name == PublicUIDName {
 		results <- UserInfo{
@@ -51,6 +99,14 @@ func identifyUser(ctx context.Context, kbpki KBPKI, name, reason string,
 
 	userInfo, err := kbpki.Identify(ctx, name, reason)
 	if err != nil {
+		// If name doesn't resolve to a UID but still parses as a
+		// valid social assertion (e.g. "twitter:alice"), treat it as
+		// an as-yet-unresolved proof rather than a hard failure, so
+		// TLFs can be shared with users who haven't signed up yet.
+		if sa, isSocialAssertion := libkb.NormalizeSocialAssertion(name); isSocialAssertion {
+			unresolved <- sa
+			return
+		}
 		select {
 		case errCh <- err:
 		default:
@@ -76,6 +132,34 @@ func (u UIDList) Swap(i, j int) {
 	u[i], u[j] = u[j], u[i]
 }
 
+// UserOrTeamIDList can be used to lexicographically sort a mix of
+// user and team IDs.
+type UserOrTeamIDList []keybase1.UserOrTeamID
+
+func (u UserOrTeamIDList) Len() int {
+	return len(u)
+}
+
+func (u UserOrTeamIDList) Less(i, j int) bool {
+	return u[i].String() < u[j].String()
+}
+
+func (u UserOrTeamIDList) Swap(i, j int) {
+	u[i], u[j] = u[j], u[i]
+}
+
+// uidsToUserOrTeamIDs upgrades a sorted list of UIDs (as produced by
+// sortedUIDsAndNames) to the wider UserOrTeamID type used to store
+// Writers/Readers, so that a Private/Public TlfHandle can eventually
+// grow SingleTeam-style members in the same slice.
+func uidsToUserOrTeamIDs(uids []keybase1.UID) []keybase1.UserOrTeamID {
+	ids := make([]keybase1.UserOrTeamID, len(uids))
+	for i, uid := range uids {
+		ids[i] = keybase1.UserOrTeamID(uid)
+	}
+	return ids
+}
+
 func sortedUIDsAndNames(m map[keybase1.UID]libkb.NormalizedUsername) (
 	[]keybase1.UID, []string) {
 	var uids []keybase1.UID
@@ -128,32 +212,37 @@ func normalizeUserNamesInTLF(writerNames, readerNames []string) string {
 	return normalizedName
 }
 
-// identifyTlfHandle parses a TlfHandle from a split TLF name.
+// identifyTlfHandle parses a TlfHandle from a split TLF name.  ty
+// must not be tlf.SingleTeam; team folders are parsed directly by
+// parseSingleTeamTlfHandle without any per-user identify calls.
 func identifyTlfHandle(ctx context.Context, kbpki KBPKI,
-	name string, public bool,
+	name string, ty tlf.Type,
 	writerNames, readerNames []string) (*TlfHandle, string, error) {
-	if public && len(readerNames) > 0 {
+	if ty == tlf.Public && len(readerNames) > 0 {
 		panic("public folder cannot have reader names")
 	}
 
 	// parallelize the resolutions for each user
 	errCh := make(chan error, 1)
 	wc := make(chan UserInfo, len(writerNames))
+	uwc := make(chan keybase1.SocialAssertion, len(writerNames))
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	for _, user := range writerNames {
 		reason := fmt.Sprintf("To confirm %s is a writer of folder %s", user, name)
-		go identifyUser(ctx, kbpki, user, reason, errCh, wc)
+		go identifyUser(ctx, kbpki, user, reason, errCh, wc, uwc)
 	}
 
 	rc := make(chan UserInfo, len(readerNames))
+	urc := make(chan keybase1.SocialAssertion, len(readerNames))
 	for _, user := range readerNames {
 		reason := fmt.Sprintf("To confirm %s is a reader of folder %s", user, name)
-		go identifyUser(ctx, kbpki, user, reason, errCh, rc)
+		go identifyUser(ctx, kbpki, user, reason, errCh, rc, urc)
 	}
 
 	usedWNames := make(map[keybase1.UID]libkb.NormalizedUsername, len(writerNames))
 	usedRNames := make(map[keybase1.UID]libkb.NormalizedUsername, len(readerNames))
+	var unresolvedWriters, unresolvedReaders []keybase1.SocialAssertion
 	for i := 0; i < len(writerNames)+len(readerNames); i++ {
 		select {
 		case err := <-errCh:
@@ -162,6 +251,10 @@ func identifyTlfHandle(ctx context.Context, kbpki KBPKI,
 			usedWNames[userInfo.UID] = userInfo.Name
 		case userInfo := <-rc:
 			usedRNames[userInfo.UID] = userInfo.Name
+		case sa := <-uwc:
+			unresolvedWriters = append(unresolvedWriters, sa)
+		case sa := <-urc:
+			unresolvedReaders = append(unresolvedReaders, sa)
 		case <-ctx.Done():
 			return nil, "", ctx.Err()
 		}
@@ -171,55 +264,94 @@ func identifyTlfHandle(ctx context.Context, kbpki KBPKI,
 		delete(usedRNames, uid)
 	}
 
+	sort.Sort(SocialAssertionList(unresolvedWriters))
+	sort.Sort(SocialAssertionList(unresolvedReaders))
+
 	writerUIDs, writerNames := sortedUIDsAndNames(usedWNames)
 
-	canonicalName := strings.Join(writerNames, ",")
+	canonicalWriterNames := append(
+		append([]string{}, writerNames...),
+		socialAssertionStrings(unresolvedWriters)...)
+	sort.Strings(canonicalWriterNames)
+	canonicalName := strings.Join(canonicalWriterNames, ",")
 	var cachedName string
 
-	var readerUIDs []keybase1.UID
-	if public {
-		readerUIDs = []keybase1.UID{keybase1.PublicUID}
+	var readerIDs []keybase1.UserOrTeamID
+	if ty == tlf.Public {
+		readerIDs = []keybase1.UserOrTeamID{keybase1.UserOrTeamID(keybase1.PublicUID)}
 		// Public folders have the same canonical name as
 		// their non-public equivalents.
 		cachedName = canonicalName + ReaderSep + PublicUIDName
 	} else {
 		var readerNames []string
+		var readerUIDs []keybase1.UID
 		readerUIDs, readerNames = sortedUIDsAndNames(usedRNames)
-		if len(readerNames) > 0 {
-			canonicalName += ReaderSep + strings.Join(readerNames, ",")
+		readerIDs = uidsToUserOrTeamIDs(readerUIDs)
+		canonicalReaderNames := append(
+			append([]string{}, readerNames...),
+			socialAssertionStrings(unresolvedReaders)...)
+		sort.Strings(canonicalReaderNames)
+		if len(canonicalReaderNames) > 0 {
+			canonicalName += ReaderSep + strings.Join(canonicalReaderNames, ",")
 		}
 		cachedName = canonicalName
 	}
 
 	h := &TlfHandle{
-		Writers:    writerUIDs,
-		Readers:    readerUIDs,
-		cachedName: cachedName,
+		Type:              ty,
+		Writers:           uidsToUserOrTeamIDs(writerUIDs),
+		Readers:           readerIDs,
+		UnresolvedWriters: unresolvedWriters,
+		UnresolvedReaders: unresolvedReaders,
+		cachedName:        cachedName,
 	}
 
 	return h, canonicalName, nil
 }
 
+// singleTeamNamePrefix is the prefix ParseTlfHandle recognizes for a
+// SingleTeam folder name, e.g. "team:acme" or the bare "acme" when
+// parsed in a /keybase/team/ path context.
+const singleTeamNamePrefix = "team:"
+
+// parseSingleTeamTlfHandle builds a SingleTeam TlfHandle directly
+// from a team name, without doing any per-user identify calls: team
+// membership, not an explicit writer/reader list, governs access.
+func parseSingleTeamTlfHandle(ctx context.Context, kbpki KBPKI, name string) (
+	*TlfHandle, error) {
+	teamName := strings.TrimPrefix(name, singleTeamNamePrefix)
+	teamID, err := kbpki.ResolveTeamName(ctx, teamName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TlfHandle{
+		Type:       tlf.SingleTeam,
+		Writers:    []keybase1.UserOrTeamID{keybase1.UserOrTeamID(teamID)},
+		cachedName: teamName,
+	}, nil
+}
+
 // IsPublic returns whether or not this TlfHandle represents a public
 // top-level folder.
 func (h *TlfHandle) IsPublic() bool {
-	return len(h.Readers) == 1 && h.Readers[0].Equal(keybase1.PublicUID)
+	return h.Type == tlf.Public
 }
 
 // IsPrivateShare returns whether or not this TlfHandle represents a
 // private share (some non-public directory with more than one writer).
 func (h *TlfHandle) IsPrivateShare() bool {
-	return !h.IsPublic() && len(h.Writers) > 1
+	return h.Type == tlf.Private && len(h.Writers) > 1
 }
 
 // HasPublic represents whether this top-level folder should have a
 // corresponding public top-level folder.
 func (h *TlfHandle) HasPublic() bool {
-	return len(h.Readers) == 0
+	return h.Type == tlf.Private && len(h.Readers) == 0
 }
 
-func (h *TlfHandle) findUserInList(user keybase1.UID,
-	users []keybase1.UID) bool {
+func (h *TlfHandle) findUserInList(user keybase1.UserOrTeamID,
+	users []keybase1.UserOrTeamID) bool {
 	// TODO: this could be more efficient with a cached map/set
 	for _, u := range users {
 		if u == user {
@@ -230,26 +362,136 @@ func (h *TlfHandle) findUserInList(user keybase1.UID,
 }
 
 // IsWriter returns whether or not the given user is a writer for the
-// top-level folder represented by this TlfHandle.
-func (h *TlfHandle) IsWriter(user keybase1.UID) bool {
-	return h.findUserInList(user, h.Writers)
+// top-level folder represented by this TlfHandle.  For a SingleTeam
+// handle this asks kbpki for the user's role in the team, rather
+// than consulting a list.
+func (h *TlfHandle) IsWriter(
+	ctx context.Context, kbpki KBPKI, user keybase1.UserOrTeamID) (
+	bool, error) {
+	if h.Type == tlf.SingleTeam {
+		role, err := kbpki.GetTeamRole(ctx, keybase1.TeamID(h.Writers[0]), user)
+		if err != nil {
+			return false, err
+		}
+		return role.IsWriterOrAbove(), nil
+	}
+	return h.findUserInList(user, h.Writers), nil
 }
 
 // IsReader returns whether or not the given user is a reader for the
-// top-level folder represented by this TlfHandle.
-func (h *TlfHandle) IsReader(user keybase1.UID) bool {
-	return h.IsPublic() || h.findUserInList(user, h.Readers) || h.IsWriter(user)
+// top-level folder represented by this TlfHandle.  For a SingleTeam
+// handle this asks kbpki for the user's role in the team, rather
+// than consulting a list.
+func (h *TlfHandle) IsReader(
+	ctx context.Context, kbpki KBPKI, user keybase1.UserOrTeamID) (
+	bool, error) {
+	if h.Type == tlf.Public {
+		return true, nil
+	}
+	if h.Type == tlf.SingleTeam {
+		role, err := kbpki.GetTeamRole(ctx, keybase1.TeamID(h.Writers[0]), user)
+		if err != nil {
+			return false, err
+		}
+		return role.IsReaderOrAbove(), nil
+	}
+	isWriter, err := h.IsWriter(ctx, kbpki, user)
+	if err != nil {
+		return false, err
+	}
+	return isWriter || h.findUserInList(user, h.Readers), nil
+}
+
+// IdentifyBehavior selects how hard ToString (and the rest of the
+// TlfHandle resolution path) is allowed to work to turn a UID/team
+// ID into a display name.
+type IdentifyBehavior int
+
+const (
+	// IdentifyDefault resolves names the normal way: it's allowed to
+	// make KBPKI calls, which may block on the network or trigger
+	// tracker popups.
+	IdentifyDefault IdentifyBehavior = iota
+	// IdentifyOffline never calls out to KBPKI; it renders whatever
+	// it can from the local tlfNameCache and falls back to a raw
+	// "uid:"/"team:" placeholder for everything else.  Use this for
+	// UI that must stay responsive (e.g. a favorites list) even when
+	// the Keybase service is unreachable or slow.
+	IdentifyOffline
+)
+
+// tlfNameCache is a small local UID/TeamID -> display name cache
+// used by IdentifyOffline, populated opportunistically whenever a
+// name resolves the normal way.
+var tlfNameCache = newOfflineNameCache()
+
+type offlineNameCache struct {
+	lock  sync.RWMutex
+	names map[keybase1.UserOrTeamID]libkb.NormalizedUsername
 }
 
-func resolveUids(ctx context.Context, config Config,
-	uids []keybase1.UID) string {
-	names := make([]string, 0, len(uids))
+func newOfflineNameCache() *offlineNameCache {
+	return &offlineNameCache{
+		names: make(map[keybase1.UserOrTeamID]libkb.NormalizedUsername),
+	}
+}
+
+func (c *offlineNameCache) get(id keybase1.UserOrTeamID) (
+	libkb.NormalizedUsername, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	name, ok := c.names[id]
+	return name, ok
+}
+
+func (c *offlineNameCache) put(id keybase1.UserOrTeamID, name libkb.NormalizedUsername) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.names[id] = name
+}
+
+func resolveUserOrTeamIDs(ctx context.Context, config Config,
+	ids []keybase1.UserOrTeamID, ib IdentifyBehavior) string {
+	names := make([]string, 0, len(ids))
 	// TODO: parallelize?
-	for _, uid := range uids {
+	for _, id := range ids {
+		if id.IsTeamOrSubteam() {
+			if ib == IdentifyOffline {
+				if cached, ok := tlfNameCache.get(id); ok {
+					names = append(names, cached.String())
+				} else {
+					names = append(names, fmt.Sprintf("team:%s", id))
+				}
+				continue
+			}
+			teamName, err := config.KBPKI().GetNormalizedTeamName(
+				ctx, keybase1.TeamID(id))
+			if err == nil {
+				tlfNameCache.put(id, libkb.NewNormalizedUsername(teamName.String()))
+				names = append(names, teamName.String())
+				continue
+			}
+			config.Reporter().Report(RptE, WrapError{err})
+			names = append(names, fmt.Sprintf("team:%s", id))
+			continue
+		}
+
+		uid := keybase1.UID(id)
 		if uid.Equal(keybase1.PublicUID) {
 			// PublicUIDName is already normalized.
 			names = append(names, PublicUIDName)
-		} else if name, err := config.KBPKI().GetNormalizedUsername(ctx, uid); err == nil {
+			continue
+		}
+		if ib == IdentifyOffline {
+			if cached, ok := tlfNameCache.get(id); ok {
+				names = append(names, cached.String())
+			} else {
+				names = append(names, fmt.Sprintf("uid:%s", uid))
+			}
+			continue
+		}
+		if name, err := config.KBPKI().GetNormalizedUsername(ctx, uid); err == nil {
+			tlfNameCache.put(id, name)
 			names = append(names, string(name))
 		} else {
 			config.Reporter().Report(RptE, WrapError{err})
@@ -261,24 +503,127 @@ func resolveUids(ctx context.Context, config Config,
 	return strings.Join(names, ",")
 }
 
-// ToString returns a string representation of this TlfHandle.
-func (h *TlfHandle) ToString(ctx context.Context, config Config) string {
+// ToString returns a string representation of this TlfHandle.  ib
+// controls how hard name resolution is allowed to work: IdentifyOffline
+// never blocks on KBPKI, so callers rendering e.g. a favorites list
+// can stay responsive even if the Keybase service is slow or
+// unreachable.
+func (h *TlfHandle) ToString(
+	ctx context.Context, config Config, ib IdentifyBehavior) string {
 	h.cacheMutex.Lock()
-	defer h.cacheMutex.Unlock()
-	if h.cachedName != "" {
+	cachedName := h.cachedName
+	h.cacheMutex.Unlock()
+	if cachedName != "" {
 		// TODO: we should expire this cache periodically
-		return h.cachedName
+		return cachedName
 	}
 
-	h.cachedName = resolveUids(ctx, config, h.Writers)
+	// Resolved names and unresolved assertions sort together, as in
+	// the canonical name built by identifyTlfHandle.
+	writerNames := socialAssertionStrings(h.UnresolvedWriters)
+	if len(h.Writers) > 0 {
+		writerNames = append(writerNames, strings.Split(
+			resolveUserOrTeamIDs(ctx, config, h.Writers, ib), ",")...)
+	}
+	sort.Strings(writerNames)
+	name := strings.Join(writerNames, ",")
 
 	// assume only additional readers are listed
-	if len(h.Readers) > 0 {
-		h.cachedName += ReaderSep + resolveUids(ctx, config, h.Readers)
+	if len(h.Readers) > 0 || len(h.UnresolvedReaders) > 0 {
+		readerNames := socialAssertionStrings(h.UnresolvedReaders)
+		if len(h.Readers) > 0 {
+			readerNames = append(readerNames, strings.Split(
+				resolveUserOrTeamIDs(ctx, config, h.Readers, ib), ",")...)
+		}
+		sort.Strings(readerNames)
+		name += ReaderSep + strings.Join(readerNames, ",")
+	}
+
+	if h.ConflictInfo != nil {
+		name += h.ConflictInfo.String()
+	}
+	if h.FinalizedInfo != nil {
+		name += h.FinalizedInfo.String()
+	}
+
+	if ib == IdentifyOffline {
+		// This name may contain uid:/team: placeholders for anything
+		// not in the local cache, so don't let it get stuck as the
+		// permanent cachedName.
+		return name
 	}
 
 	// TODO: don't cache if there were errors?
-	return h.cachedName
+	h.cacheMutex.Lock()
+	h.cachedName = name
+	h.cacheMutex.Unlock()
+	return name
+}
+
+// deepCopy returns a new TlfHandle with the same contents as h, but
+// sharing no mutable state (and none of h's caches) with it.
+func (h *TlfHandle) deepCopy() *TlfHandle {
+	return &TlfHandle{
+		Type:              h.Type,
+		Writers:           append([]keybase1.UserOrTeamID{}, h.Writers...),
+		Readers:           append([]keybase1.UserOrTeamID{}, h.Readers...),
+		UnresolvedWriters: append([]keybase1.SocialAssertion{}, h.UnresolvedWriters...),
+		UnresolvedReaders: append([]keybase1.SocialAssertion{}, h.UnresolvedReaders...),
+		ConflictInfo:      h.ConflictInfo,
+		FinalizedInfo:     h.FinalizedInfo,
+	}
+}
+
+// WithConflictInfo returns a new TlfHandle equal to h, but with its
+// ConflictInfo set to info.
+func (h *TlfHandle) WithConflictInfo(info *tlf.HandleExtension) *TlfHandle {
+	newHandle := h.deepCopy()
+	newHandle.ConflictInfo = info
+	return newHandle
+}
+
+// WithFinalizedInfo returns a new TlfHandle equal to h, but with its
+// FinalizedInfo set to info.
+func (h *TlfHandle) WithFinalizedInfo(info *tlf.HandleExtension) *TlfHandle {
+	newHandle := h.deepCopy()
+	newHandle.FinalizedInfo = info
+	return newHandle
+}
+
+func userOrTeamIDsEqual(a, b []keybase1.UserOrTeamID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func socialAssertionsEqual(a, b []keybase1.SocialAssertion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolvesTo returns true if h and other represent the same set of
+// writers and readers, ignoring ConflictInfo/FinalizedInfo.  This
+// lets a live handle be matched against its conflicted or finalized
+// siblings.
+func (h *TlfHandle) ResolvesTo(other *TlfHandle) bool {
+	return h.Type == other.Type &&
+		userOrTeamIDsEqual(h.Writers, other.Writers) &&
+		userOrTeamIDsEqual(h.Readers, other.Readers) &&
+		socialAssertionsEqual(h.UnresolvedWriters, other.UnresolvedWriters) &&
+		socialAssertionsEqual(h.UnresolvedReaders, other.UnresolvedReaders)
 }
 
 // ToBytes marshals this TlfHandle.
@@ -289,7 +634,7 @@ func (h *TlfHandle) ToBytes(config Config) (out []byte, err error) {
 		return h.cachedBytes, nil
 	}
 
-	if out, err = config.Codec().Encode(h); err != nil {
+	if out, err = config.Codec().Encode(h); err == nil {
 		h.cachedBytes = out
 	}
 	return out, err
@@ -299,35 +644,81 @@ func (h *TlfHandle) ToBytes(config Config) (out []byte, err error) {
 // suitable for KBPKI calls.
 func (h *TlfHandle) ToKBFolder(ctx context.Context, config Config) keybase1.Folder {
 	return keybase1.Folder{
-		Name:    h.ToString(ctx, config),
+		Name:    h.ToString(ctx, config, IdentifyDefault),
 		Private: !h.IsPublic(),
 	}
 }
 
-// Equal returns true if two TlfHandles are equal.
+// extensionEqual returns true if a and b represent the same handle
+// extension (nil counts as equal to nil only).
+func extensionEqual(a, b *tlf.HandleExtension) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Equal returns true if two TlfHandles are equal, including their
+// ConflictInfo/FinalizedInfo extensions.  It compares the canonical
+// (writers, readers, type, extensions) tuple directly rather than
+// round-tripping through ToBytes, since this gets called on every
+// comparison in hot paths like block-tree walks.
 func (h *TlfHandle) Equal(rhs *TlfHandle, config Config) bool {
-	hBytes, _ := h.ToBytes(config)
-	rhsBytes, _ := rhs.ToBytes(config)
-	return bytes.Equal(hBytes, rhsBytes)
+	return h.Type == rhs.Type &&
+		userOrTeamIDsEqual(h.Writers, rhs.Writers) &&
+		userOrTeamIDsEqual(h.Readers, rhs.Readers) &&
+		socialAssertionsEqual(h.UnresolvedWriters, rhs.UnresolvedWriters) &&
+		socialAssertionsEqual(h.UnresolvedReaders, rhs.UnresolvedReaders) &&
+		extensionEqual(h.ConflictInfo, rhs.ConflictInfo) &&
+		extensionEqual(h.FinalizedInfo, rhs.FinalizedInfo)
 }
 
-// Users returns a list of all reader and writer UIDs for the tlf.
-func (h *TlfHandle) Users() []keybase1.UID {
-	var users []keybase1.UID
-	for _, uid := range h.Writers {
-		users = append(users, uid)
+// Users returns a list of all reader and writer IDs (users or, for a
+// SingleTeam handle, the single team) for the tlf.  Unresolved
+// social assertions aren't included, since they don't have a UID
+// yet; see ResolveAgain.
+func (h *TlfHandle) Users() []keybase1.UserOrTeamID {
+	var users []keybase1.UserOrTeamID
+	for _, id := range h.Writers {
+		users = append(users, id)
 	}
-	for _, uid := range h.Readers {
-		users = append(users, uid)
+	for _, id := range h.Readers {
+		users = append(users, id)
 	}
 	return users
 }
 
+// CanonicalTlfName is a TLF name that's already in canonical form:
+// writers and readers sorted, no duplicates between the two lists,
+// and every resolvable assertion resolved.  It's a distinct type so a
+// display string that hasn't been through ParseTlfHandle can't be
+// passed where a canonical name is expected.
+type CanonicalTlfName string
+
 // ParseTlfHandle parses a TlfHandle from an encoded string. See
-// ToString for the opposite direction.
+// ToString for the opposite direction.  ty selects whether name is
+// parsed as a private, public, or single-team folder name, but a
+// leading "team:" on name always wins: callers that haven't resolved
+// path context into ty yet (e.g. a raw /keybase/private/<name> lookup
+// that turns out to name a team folder) still get a SingleTeam
+// handle.
 func ParseTlfHandle(
-	ctx context.Context, kbpki KBPKI, name string, public bool) (
+	ctx context.Context, kbpki KBPKI, name string, ty tlf.Type) (
 	*TlfHandle, error) {
+	if ty == tlf.SingleTeam || strings.HasPrefix(name, singleTeamNamePrefix) {
+		return parseSingleTeamTlfHandle(ctx, kbpki, name)
+	}
+
+	// A conflicted-copy or account-reset suffix, if any, is on the
+	// very end of the name and has nothing to do with the
+	// writer/reader lists, so pull it off before doing anything else
+	// and re-append it once we know the canonical form of the rest.
+	originalName := name
+	name, extension, err := tlf.SplitExtension(name)
+	if err != nil {
+		return nil, err
+	}
+
 	// Before parsing the tlf handle (which results in identify
 	// calls that cause tracker popups), first see if there's any
 	// quick normalization of usernames we can do.  For example,
@@ -343,14 +734,17 @@ func ParseTlfHandle(
 
 	hasPublic := len(readerNames) == 0
 
-	if public && !hasPublic {
+	if ty == tlf.Public && !hasPublic {
 		// No public folder exists for this folder.
 		return nil, NoSuchNameError{Name: name}
 	}
 
 	normalizedName := normalizeUserNamesInTLF(writerNames, readerNames)
 	if normalizedName != name {
-		return nil, TlfNameNotCanonical{name, normalizedName}
+		if extension != nil {
+			normalizedName += extension.String()
+		}
+		return nil, TlfNameNotCanonical{originalName, CanonicalTlfName(normalizedName)}
 	}
 
 	currentUID, err := kbpki.GetCurrentUID(ctx)
@@ -359,7 +753,7 @@ func ParseTlfHandle(
 	}
 
 	canRead := false
-	if public {
+	if ty == tlf.Public {
 		canRead = true
 	} else {
 		for _, writerName := range append(writerNames, readerNames...) {
@@ -386,14 +780,218 @@ func ParseTlfHandle(
 	}
 
 	h, canonicalName, err := identifyTlfHandle(
-		ctx, kbpki, name, public, writerNames, readerNames)
+		ctx, kbpki, name, ty, writerNames, readerNames)
 	if err != nil {
 		return nil, err
 	}
 
-	if canonicalName != name {
-		return nil, TlfNameNotCanonical{name, canonicalName}
+	if extension != nil {
+		switch extension.Type {
+		case tlf.HandleExtensionConflict:
+			h.ConflictInfo = extension
+		case tlf.HandleExtensionFinalized:
+			h.FinalizedInfo = extension
+		}
+		canonicalName += extension.String()
+	}
+
+	if canonicalName != originalName {
+		return nil, TlfNameNotCanonical{originalName, CanonicalTlfName(canonicalName)}
 	}
 
 	return h, nil
-}
\ No newline at end of file
+}
+
+// ResolveAgain tries to resolve any of h's as-yet-unresolved social
+// assertions (e.g. because the user has since signed up on
+// Keybase), and returns a new handle with any newly-resolved users
+// promoted into Writers/Readers.  If nothing resolved, it returns h
+// unchanged. This is meant to be called periodically in the
+// background, not inline with a read or write, since it can involve
+// network requests.  It's a no-op for SingleTeam handles, which have
+// no unresolved assertions.
+func (h *TlfHandle) ResolveAgain(ctx context.Context, kbpki KBPKI) (
+	*TlfHandle, error) {
+	if len(h.UnresolvedWriters) == 0 && len(h.UnresolvedReaders) == 0 {
+		return h, nil
+	}
+
+	newWriters := append([]keybase1.UserOrTeamID{}, h.Writers...)
+	newReaders := append([]keybase1.UserOrTeamID{}, h.Readers...)
+
+	newUnresolvedWriters, err := resolveAssertionsAgain(
+		ctx, kbpki, h.UnresolvedWriters, &newWriters)
+	if err != nil {
+		return nil, err
+	}
+	newUnresolvedReaders, err := resolveAssertionsAgain(
+		ctx, kbpki, h.UnresolvedReaders, &newReaders)
+	if err != nil {
+		return nil, err
+	}
+	if len(newUnresolvedWriters) == len(h.UnresolvedWriters) &&
+		len(newUnresolvedReaders) == len(h.UnresolvedReaders) {
+		return h, nil
+	}
+
+	sort.Sort(UserOrTeamIDList(newWriters))
+	sort.Sort(UserOrTeamIDList(newReaders))
+
+	return &TlfHandle{
+		Type:              h.Type,
+		Writers:           newWriters,
+		Readers:           newReaders,
+		UnresolvedWriters: newUnresolvedWriters,
+		UnresolvedReaders: newUnresolvedReaders,
+		ConflictInfo:      h.ConflictInfo,
+		FinalizedInfo:     h.FinalizedInfo,
+	}, nil
+}
+
+// resolveAssertionsAgain tries to resolve each assertion in sas,
+// appending any that now resolve to *ids and returning the
+// remainder that are still unresolved.
+func resolveAssertionsAgain(ctx context.Context, kbpki KBPKI,
+	sas []keybase1.SocialAssertion, ids *[]keybase1.UserOrTeamID) (
+	[]keybase1.SocialAssertion, error) {
+	var stillUnresolved []keybase1.SocialAssertion
+	for _, sa := range sas {
+		uid, err := kbpki.Resolve(ctx, sa.String())
+		if err != nil {
+			// Still not resolvable; keep waiting.
+			stillUnresolved = append(stillUnresolved, sa)
+			continue
+		}
+		*ids = append(*ids, keybase1.UserOrTeamID(uid))
+	}
+	return stillUnresolved, nil
+}
+
+// quickResolveNames resolves each of names via kbpki.Resolve only
+// (never Identify), so it can't block on a tracker popup or a slow
+// network round trip to a proof server.  Names that don't resolve
+// quickly but parse as social assertions are returned unresolved;
+// anything else is silently dropped, since the background full parse
+// in ParseTlfHandlePreferredQuick will surface any real error.
+func quickResolveNames(ctx context.Context, kbpki KBPKI, names []string) (
+	ids []keybase1.UserOrTeamID, unresolved []keybase1.SocialAssertion) {
+	for _, name := range names {
+		uid, err := kbpki.Resolve(ctx, name)
+		if err == nil {
+			id := keybase1.UserOrTeamID(uid)
+			ids = append(ids, id)
+			continue
+		}
+		if sa, isSocialAssertion := libkb.NormalizeSocialAssertion(name); isSocialAssertion {
+			unresolved = append(unresolved, sa)
+		}
+	}
+	return ids, unresolved
+}
+
+// quickResolveTlfHandle builds a partial TlfHandle for name using
+// only quick, non-blocking resolution; see quickResolveNames.  The
+// handle it returns is never itself canonical -- it exists purely to
+// give UI something to render immediately while the real
+// ParseTlfHandle runs in the background.
+func quickResolveTlfHandle(ctx context.Context, kbpki KBPKI, name string,
+	ty tlf.Type) (*TlfHandle, error) {
+	plainName, _, err := tlf.SplitExtension(name)
+	if err != nil {
+		return nil, err
+	}
+	writerNames, readerNames, err := splitTLFNameIntoWritersAndReaders(plainName)
+	if err != nil {
+		return nil, err
+	}
+
+	writers, unresolvedWriters := quickResolveNames(ctx, kbpki, writerNames)
+	readers, unresolvedReaders := quickResolveNames(ctx, kbpki, readerNames)
+	sort.Sort(UserOrTeamIDList(writers))
+	sort.Sort(UserOrTeamIDList(readers))
+	sort.Sort(SocialAssertionList(unresolvedWriters))
+	sort.Sort(SocialAssertionList(unresolvedReaders))
+
+	return &TlfHandle{
+		Type:              ty,
+		Writers:           writers,
+		Readers:           readers,
+		UnresolvedWriters: unresolvedWriters,
+		UnresolvedReaders: unresolvedReaders,
+	}, nil
+}
+
+// ParseTlfHandlePreferredQuick is like ParseTlfHandle, but returns as
+// soon as it can build a handle using only quick, non-blocking
+// resolution (see quickResolveNames), rather than waiting on
+// kbpki.Identify for every writer and reader.  The returned handle is
+// good enough to render a folder list entry, but isn't guaranteed
+// canonical.  The full identify continues in the background; its
+// result -- or nothing, if it fails -- is delivered on the returned
+// channel, which is always closed exactly once.
+func ParseTlfHandlePreferredQuick(
+	ctx context.Context, kbpki KBPKI, name string, ty tlf.Type) (
+	*TlfHandle, <-chan *TlfHandle, error) {
+	if ty == tlf.SingleTeam || strings.HasPrefix(name, singleTeamNamePrefix) {
+		// Team folders resolve in a single RPC already; there's
+		// nothing to do quickly that the full parse doesn't already
+		// do.
+		h, err := parseSingleTeamTlfHandle(ctx, kbpki, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		ch := make(chan *TlfHandle, 1)
+		ch <- h
+		close(ch)
+		return h, ch, nil
+	}
+
+	h, err := quickResolveTlfHandle(ctx, kbpki, name, ty)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan *TlfHandle, 1)
+	go func() {
+		defer close(ch)
+		// Use the UntilCanonical variant, not ParseTlfHandle directly:
+		// the whole point of this API is names with
+		// not-yet-resolved assertions (chunk1-1), and that's exactly
+		// when the background identify is expected to come back with
+		// a TlfNameNotCanonical redirect instead of a handle, once the
+		// assertion resolves. Treating that as "nothing to deliver"
+		// would mean the one case this exists for never reaches the
+		// caller.
+		full, _, err := ParseTlfHandleUntilCanonical(ctx, kbpki, name, ty)
+		if err != nil {
+			return
+		}
+		ch <- full
+	}()
+	return h, ch, nil
+}
+
+// ParseTlfHandleUntilCanonical calls ParseTlfHandle, and if it comes
+// back with a TlfNameNotCanonical redirect, follows it exactly once
+// by retrying with NameToTry.  A second redirect isn't followed:
+// ParseTlfHandle checks a name's spelling (case and ordering) before
+// resolving anything, so a name needing both fixes (e.g. "Alice#alice",
+// a writer also listed as a reader) redirects twice, and the retry's
+// TlfNameNotCanonical is then returned as the error.  It returns the resulting handle along with the chain of names visited,
+// starting with the original name, so callers like the FUSE/dokan
+// layer can emit a symlink from each alias to the canonical name.
+func ParseTlfHandleUntilCanonical(
+	ctx context.Context, kbpki KBPKI, name string, ty tlf.Type) (
+	*TlfHandle, []string, error) {
+	aliases := []string{name}
+	h, err := ParseTlfHandle(ctx, kbpki, name, ty)
+	if nc, ok := err.(TlfNameNotCanonical); ok {
+		canonicalName := string(nc.NameToTry)
+		aliases = append(aliases, canonicalName)
+		h, err = ParseTlfHandle(ctx, kbpki, canonicalName, ty)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, aliases, nil
+}
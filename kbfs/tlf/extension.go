@@ -0,0 +1,109 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package tlf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// HandleExtensionType describes what a HandleExtension represents.
+type HandleExtensionType int
+
+const (
+	// HandleExtensionConflict means the handle is a conflict-
+	// resolution copy of another handle.
+	HandleExtensionConflict HandleExtensionType = iota
+	// HandleExtensionFinalized means the handle holds the contents
+	// of a folder as they were before one of its writers reset their
+	// Keybase account.
+	HandleExtensionFinalized
+)
+
+const extensionDateFormat = "2006-01-02"
+
+var conflictSuffixRE = regexp.MustCompile(
+	`^(.*) \(conflicted copy (\d{4}-\d{2}-\d{2})(?: #(\d+))?\)$`)
+var finalizedSuffixRE = regexp.MustCompile(
+	`^(.*) \(files before account reset of ([a-zA-Z0-9_]+) (\d{4}-\d{2}-\d{2})\)$`)
+
+// HandleExtension represents a suffix appended to a TLF's canonical
+// name that marks it as a distinct copy of the live folder: either a
+// conflict-resolution branch, or the contents of the folder as they
+// stood before one of its writers reset their account.
+type HandleExtension struct {
+	Type HandleExtensionType
+	// Date is the suffix's timestamp, in seconds since the epoch.
+	Date int64
+	// Number disambiguates multiple conflict extensions with the same
+	// Date; it's monotonically increasing, starting at 1.  It isn't
+	// part of a finalized extension's suffix, so SplitExtension leaves
+	// it 0 for those.
+	Number uint16
+	// Username is only set for HandleExtensionFinalized, and names
+	// the writer whose account reset triggered the finalization.
+	Username string
+}
+
+// String formats the extension as a canonical name suffix, e.g.
+// " (conflicted copy 2016-03-14 #2)" or
+// " (files before account reset of alice 2017-01-02)".
+func (e HandleExtension) String() string {
+	date := time.Unix(e.Date, 0).UTC().Format(extensionDateFormat)
+	switch e.Type {
+	case HandleExtensionConflict:
+		if e.Number > 1 {
+			return fmt.Sprintf(" (conflicted copy %s #%d)", date, e.Number)
+		}
+		return fmt.Sprintf(" (conflicted copy %s)", date)
+	case HandleExtensionFinalized:
+		return fmt.Sprintf(
+			" (files before account reset of %s %s)", e.Username, date)
+	default:
+		return ""
+	}
+}
+
+// SplitExtension splits any canonical-name suffix off the end of
+// name, returning the base name and the parsed extension (nil if
+// name has no recognized suffix).
+func SplitExtension(name string) (string, *HandleExtension, error) {
+	if m := conflictSuffixRE.FindStringSubmatch(name); m != nil {
+		date, err := time.Parse(extensionDateFormat, m[2])
+		if err != nil {
+			return "", nil, err
+		}
+		var num uint64
+		if m[3] != "" {
+			num, err = strconv.ParseUint(m[3], 10, 16)
+			if err != nil {
+				return "", nil, err
+			}
+		} else {
+			num = 1
+		}
+		return m[1], &HandleExtension{
+			Type:   HandleExtensionConflict,
+			Date:   date.Unix(),
+			Number: uint16(num),
+		}, nil
+	}
+
+	if m := finalizedSuffixRE.FindStringSubmatch(name); m != nil {
+		date, err := time.Parse(extensionDateFormat, m[3])
+		if err != nil {
+			return "", nil, err
+		}
+		return m[1], &HandleExtension{
+			Type:     HandleExtensionFinalized,
+			Date:     date.Unix(),
+			Username: m[2],
+		}, nil
+	}
+
+	return name, nil, nil
+}
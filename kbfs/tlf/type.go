@@ -0,0 +1,39 @@
+// Copyright 2016 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package tlf contains types used to identify KBFS top-level
+// folders, shared by libkbfs and anything that needs to name a
+// folder without depending on the rest of libkbfs.
+package tlf
+
+// Type describes the flavor of a top-level folder: whether it's
+// private, public, or owned by a single Keybase team.
+type Type int
+
+const (
+	// Private represents a private folder, readable and writable
+	// only by its listed readers and writers.
+	Private Type = iota
+	// Public represents a folder that is readable by anyone, but
+	// only writable by its listed writers.
+	Public
+	// SingleTeam represents a folder owned by a single Keybase team,
+	// where read/write access tracks team membership rather than an
+	// explicit reader/writer list.
+	SingleTeam
+)
+
+// String implements the fmt.Stringer interface for Type.
+func (t Type) String() string {
+	switch t {
+	case Private:
+		return "private"
+	case Public:
+		return "public"
+	case SingleTeam:
+		return "team"
+	default:
+		return "unknown"
+	}
+}